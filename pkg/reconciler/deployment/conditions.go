@@ -0,0 +1,38 @@
+package deployment
+
+// Deployment's apps/v1 status has no room for conditions of our own
+// (its Status.Conditions is reserved for Available/Progressing/
+// ReplicaFailure), so Scheduled and AllShardsReady are surfaced as
+// annotations on the root Deployment instead. Both are "True" or
+// "False" strings, so `kubectl wait --for=jsonpath='{.metadata.
+// annotations.scheduling\.kcp\.dev/condition-scheduled}'=True` works
+// the same way it would against a real condition.
+const (
+	conditionAnnotationPrefix = "scheduling.kcp.dev/condition-"
+
+	// ConditionScheduled is "True" when the Scheduler placed every
+	// desired replica on some Ready Cluster, i.e. there's no
+	// unschedulable overflow.
+	ConditionScheduled = "scheduled"
+
+	// ConditionAllShardsReady is "True" once every virtual Deployment's
+	// ReadyReplicas matches its Replicas, and there's no unschedulable
+	// overflow.
+	ConditionAllShardsReady = "all-shards-ready"
+)
+
+func conditionAnnotation(name string) string {
+	return conditionAnnotationPrefix + name
+}
+
+func setCondition(annotations map[string]string, name string, status bool) map[string]string {
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	if status {
+		annotations[conditionAnnotation(name)] = "True"
+	} else {
+		annotations[conditionAnnotation(name)] = "False"
+	}
+	return annotations
+}
@@ -2,201 +2,276 @@ package deployment
 
 import (
 	"context"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	clusterclient "github.com/kcp-dev/kcp/pkg/client/clientset/versioned"
 	"github.com/kcp-dev/kcp/pkg/client/informers/externalversions"
 	clusterlisters "github.com/kcp-dev/kcp/pkg/client/listers/cluster/v1alpha1"
 	appsv1 "k8s.io/api/apps/v1"
-	"k8s.io/apimachinery/pkg/api/equality"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	appsv1lister "k8s.io/client-go/listers/apps/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
-	"k8s.io/klog"
+	"k8s.io/klog/v2"
 )
 
 const resyncPeriod = 10 * time.Hour
 
 // NewController returns a new Controller which splits new Deployment objects
 // into N virtual Deployments labeled for each Cluster that exists at the time
-// the Deployment is created.
-func NewController(cfg *rest.Config) *Controller {
+// the Deployment is created. ctx's cancellation stops the informers started
+// here; reconciles log through the ctx later passed to Start or Run, not
+// through this one.
+//
+// If leader election is enabled via WithLeaderElection, the informers are
+// left cold (not started, no cache sync) until this replica wins the
+// lease: see Run. Otherwise NewController starts them immediately, as
+// it always has.
+func NewController(ctx context.Context, cfg *rest.Config, opts ...Option) *Controller {
 	kubeClient := kubernetes.NewForConfigOrDie(cfg)
-	stopCh := make(chan struct{}) // TODO: hook this up to SIGTERM/SIGINT
 
 	c := &Controller{
 		kubeClient:   kubeClient,
-		stopCh:       stopCh,
-		queue:        workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
-		clusterQueue: workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter()),
+		queue:        workqueue.NewTypedRateLimitingQueue(workqueue.DefaultTypedControllerRateLimiter[cache.ObjectName]()),
+		clusterQueue: workqueue.NewTypedRateLimitingQueue(workqueue.DefaultTypedControllerRateLimiter[cache.ObjectName]()),
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.applyLeaderElectionDefaults()
 
-	csif := externalversions.NewSharedInformerFactoryWithOptions(clusterclient.NewForConfigOrDie(cfg), resyncPeriod)
-	csif.Cluster().V1alpha1().Clusters().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+	c.csif = externalversions.NewSharedInformerFactoryWithOptions(clusterclient.NewForConfigOrDie(cfg), resyncPeriod)
+	c.csif.Cluster().V1alpha1().Clusters().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc:    func(obj interface{}) { c.enqueueCluster(obj) },
 		UpdateFunc: func(_, obj interface{}) { c.enqueueCluster(obj) },
 		DeleteFunc: func(obj interface{}) { c.enqueueCluster(obj) },
 	})
-	csif.WaitForCacheSync(stopCh)
-	csif.Start(stopCh)
-	c.clusterLister = csif.Cluster().V1alpha1().Clusters().Lister()
+	c.clusterLister = c.csif.Cluster().V1alpha1().Clusters().Lister()
 
-	sif := informers.NewSharedInformerFactoryWithOptions(kubeClient, resyncPeriod)
-	sif.Apps().V1().Deployments().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+	c.sif = informers.NewSharedInformerFactoryWithOptions(kubeClient, resyncPeriod)
+	c.sif.Apps().V1().Deployments().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc:    func(obj interface{}) { c.enqueue(obj) },
 		UpdateFunc: func(_, obj interface{}) { c.enqueue(obj) },
 	})
-	sif.WaitForCacheSync(stopCh)
-	sif.Start(stopCh)
-	c.indexer = sif.Apps().V1().Deployments().Informer().GetIndexer()
-	c.lister = sif.Apps().V1().Deployments().Lister()
+	c.indexer = c.sif.Apps().V1().Deployments().Informer().GetIndexer()
+	c.lister = c.sif.Apps().V1().Deployments().Lister()
+
+	if c.scheduler == nil {
+		c.scheduler = WeightedRoundRobin{}
+	}
+
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartLogging(klog.Infof)
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	c.recorder = eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "deployment-splitter"})
+
+	if !c.leaderElection.enabled {
+		c.startInformers(ctx)
+	}
 
 	return c
 }
 
+// startInformers starts both shared informer factories and blocks until
+// their caches have synced. Safe to call exactly once.
+func (c *Controller) startInformers(ctx context.Context) {
+	c.csif.Start(ctx.Done())
+	c.csif.WaitForCacheSync(ctx.Done())
+	c.sif.Start(ctx.Done())
+	c.sif.WaitForCacheSync(ctx.Done())
+}
+
 type Controller struct {
 	kubeClient          kubernetes.Interface
-	stopCh              chan struct{}
-	queue, clusterQueue workqueue.RateLimitingInterface
+	queue, clusterQueue workqueue.TypedRateLimitingInterface[cache.ObjectName]
 	lister              appsv1lister.DeploymentLister
 	clusterLister       clusterlisters.ClusterLister
 	indexer             cache.Indexer
+	csif                externalversions.SharedInformerFactory
+	sif                 informers.SharedInformerFactory
+	scheduler           Scheduler
+	forceApply          bool
+	recorder            record.EventRecorder
+	leaderElection      leaderElectionConfig
+	leading             atomic.Bool
+}
+
+// Option configures optional Controller behavior at construction time.
+type Option func(*Controller)
+
+// WithForceApply makes the controller's Server-Side Apply calls take
+// ownership of conflicting fields (Force: true) instead of failing when
+// another field manager holds them. Off by default, since forcing is a
+// conflict-resolution escape hatch an operator should opt into
+// deliberately.
+func WithForceApply(force bool) Option {
+	return func(c *Controller) { c.forceApply = force }
+}
+
+// WithScheduler installs scheduler in place of the default
+// WeightedRoundRobin. Use NewLeastLoaded to balance overall cluster
+// utilization instead of per-root proportional share.
+func WithScheduler(scheduler Scheduler) Option {
+	return func(c *Controller) { c.scheduler = scheduler }
 }
 
 func (c *Controller) enqueueCluster(obj interface{}) {
-	key, err := cache.MetaNamespaceKeyFunc(obj)
+	name, err := cache.DeletionHandlingObjectToName(obj)
 	if err != nil {
 		runtime.HandleError(err)
 		return
 	}
-	c.clusterQueue.AddRateLimited(key)
+	c.clusterQueue.AddRateLimited(name)
 }
 
+// enqueue queues a root Deployment for reconciliation. Virtual
+// Deployments (carrying ownedByLabel) are skipped: without this guard
+// every virtual Deployment reconcile splits itself again, creating
+// virtual Deployments of virtual Deployments without bound.
 func (c *Controller) enqueue(obj interface{}) {
-	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if d, ok := obj.(*appsv1.Deployment); ok && d.Labels[ownedByLabel] != "" {
+		return
+	}
+	name, err := cache.DeletionHandlingObjectToName(obj)
 	if err != nil {
 		runtime.HandleError(err)
 		return
 	}
-	c.queue.AddRateLimited(key)
+	c.queue.AddRateLimited(name)
 }
 
-func (c *Controller) Start(numThreads int) {
-	defer c.queue.ShutDown()
-	defer c.clusterQueue.ShutDown()
+// Start runs numThreads workers processing both workqueues until ctx is
+// canceled, then drains them before returning.
+func (c *Controller) Start(ctx context.Context, numThreads int) {
+	defer runtime.HandleCrash()
+
+	logger := klog.FromContext(ctx)
+	var wg sync.WaitGroup
 	for i := 0; i < numThreads; i++ {
-		go wait.Until(c.startWorker, time.Second, c.stopCh)
-		go wait.Until(c.startClusterWorker, time.Second, c.stopCh)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			wait.Until(func() { c.startWorker(ctx) }, time.Second, ctx.Done())
+		}()
+		go func() {
+			defer wg.Done()
+			wait.Until(func() { c.startClusterWorker(ctx) }, time.Second, ctx.Done())
+		}()
 	}
-	klog.Infof("Starting workers")
-	<-c.stopCh
-	klog.Infof("Stopping workers")
+	logger.Info("Starting workers")
+	<-ctx.Done()
+	logger.Info("Stopping workers")
+	// Shut the queues down first so that queue.Get() unblocks and each
+	// worker's wait.Until loop can return; only then do the workers'
+	// goroutines call wg.Done(), so this must happen before wg.Wait().
+	c.queue.ShutDown()
+	c.clusterQueue.ShutDown()
+	wg.Wait()
 }
 
-func (c *Controller) startWorker() {
-	for c.processNextWorkItem() {
+func (c *Controller) startWorker(ctx context.Context) {
+	for c.processNextWorkItem(ctx) {
 	}
 }
 
-func (c *Controller) startClusterWorker() {
-	for c.processNextClusterWorkItem() {
+func (c *Controller) startClusterWorker(ctx context.Context) {
+	for c.processNextClusterWorkItem(ctx) {
 	}
 }
 
-func (c *Controller) processNextWorkItem() bool {
+func (c *Controller) processNextWorkItem(ctx context.Context) bool {
 	// Wait until there is a new item in the working queue
-	k, quit := c.queue.Get()
+	name, quit := c.queue.Get()
 	if quit {
 		return false
 	}
-	key := k.(string)
 
 	// No matter what, tell the queue we're done with this key, to unblock
 	// other workers.
-	defer c.queue.Done(key)
+	defer c.queue.Done(name)
 
-	err := c.process(key)
-	handleErr(err, key, c.queue)
+	err := c.process(ctx, name)
+	handleErr(ctx, err, name, c.queue)
 	return true
 }
 
-func (c *Controller) processNextClusterWorkItem() bool {
+func (c *Controller) processNextClusterWorkItem(ctx context.Context) bool {
 	// Wait until there is a new item in the working queue
-	k, quit := c.clusterQueue.Get()
+	name, quit := c.clusterQueue.Get()
 	if quit {
 		return false
 	}
-	key := k.(string)
 
 	// No matter what, tell the queue we're done with this key, to unblock
 	// other workers.
-	defer c.queue.Done(key)
+	defer c.clusterQueue.Done(name)
 
-	err := c.processCluster(key)
-	handleErr(err, key, c.clusterQueue)
+	err := c.processCluster(ctx, name)
+	handleErr(ctx, err, name, c.clusterQueue)
 	return true
 }
 
-func handleErr(err error, key string, queue workqueue.RateLimitingInterface) {
+func handleErr(ctx context.Context, err error, name cache.ObjectName, queue workqueue.TypedRateLimitingInterface[cache.ObjectName]) {
 	// Reconcile worked, nothing else to do for this workqueue item.
 	if err == nil {
-		queue.Forget(key)
+		queue.Forget(name)
 		return
 	}
 
+	logger := klog.FromContext(ctx)
+
 	// Re-enqueue up to 5 times.
-	num := queue.NumRequeues(key)
+	num := queue.NumRequeues(name)
 	if num < 5 {
-		klog.Errorf("Error reconciling key %q, retrying... (#%d): %v", key, num, err)
-		queue.AddRateLimited(key)
+		logger.Error(err, "Error reconciling, retrying", "key", name, "retry", num)
+		queue.AddRateLimited(name)
 		return
 	}
 
 	// Give up and report error elsewhere.
-	queue.Forget(key)
+	queue.Forget(name)
 	runtime.HandleError(err)
-	klog.Infof("Dropping key %q after failed retries: %v", key, err)
+	logger.Info("Dropping key after failed retries", "key", name, "err", err)
 }
 
-func (c *Controller) process(key string) error {
-	obj, exists, err := c.indexer.GetByKey(key)
+func (c *Controller) process(ctx context.Context, name cache.ObjectName) error {
+	logger := klog.FromContext(ctx).WithValues("key", name)
+	ctx = klog.NewContext(ctx, logger)
+
+	obj, exists, err := c.indexer.GetByKey(name.String())
 	if err != nil {
 		return err
 	}
 
 	if !exists {
-		klog.Infof("Object with key %q was deleted", key)
+		logger.Info("Object was deleted")
 		return nil
 	}
-	current := obj.(*appsv1.Deployment)
-	previous := current.DeepCopy()
 
-	ctx := context.TODO()
-	if err := c.reconcile(ctx, current); err != nil {
-		return err
-	}
-
-	// If the object being reconciled changed as a result, update it.
-	if !equality.Semantic.DeepEqual(previous, current) {
-		_, uerr := c.kubeClient.AppsV1().Deployments(current.Namespace).Update(ctx, current, metav1.UpdateOptions{})
-		return uerr
-	}
-	if !equality.Semantic.DeepEqual(previous.Status, current.Status) {
-		_, uerr := c.kubeClient.AppsV1().Deployments(current.Namespace).UpdateStatus(ctx, current, metav1.UpdateOptions{})
-		return uerr
-	}
-	return err
+	// reconcile applies every change it makes via Server-Side Apply as it
+	// goes, so there's no read-modify-write conflict window to close
+	// here afterwards.
+	return c.reconcile(ctx, obj.(*appsv1.Deployment))
 }
 
-// processCluster triggers a full rebalance of all roots.
-func (c *Controller) processCluster(string) error {
+// processCluster triggers a full rebalance of all roots. Each root's own
+// reconcile decides whether anything actually changed and records at
+// most one event of each reason for it, so a single Cluster flap doesn't
+// produce an event per virtual Deployment it touches.
+func (c *Controller) processCluster(ctx context.Context, name cache.ObjectName) error {
+	logger := klog.FromContext(ctx).WithValues("cluster", name)
+
 	// Get all deployments, filter out non-roots, and enqueue a
 	// reconciliation of all remaining roots.
 	//
@@ -207,6 +282,7 @@ func (c *Controller) processCluster(string) error {
 	if err != nil {
 		return err
 	}
+	logger.V(2).Info("Rebalancing roots after cluster change", "deployments", len(ds))
 	for _, d := range ds {
 		if d.Labels == nil {
 			d.Labels = map[string]string{}
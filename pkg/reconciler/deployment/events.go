@@ -0,0 +1,23 @@
+package deployment
+
+// Event reasons recorded against a root Deployment as it's split and
+// rebalanced across Clusters.
+const (
+	// ReasonSplitAcrossClusters fires the first time a root Deployment
+	// gets any virtual Deployments.
+	ReasonSplitAcrossClusters = "SplitAcrossClusters"
+
+	// ReasonRebalanced fires when an already-split root Deployment's
+	// per-cluster replica counts change on a later reconcile.
+	ReasonRebalanced = "Rebalanced"
+
+	// ReasonClusterUnavailable fires once per affected root Deployment
+	// when a Cluster it was scheduled onto drops out of the schedule
+	// (removed, gone NotReady, or over capacity) and its virtual
+	// Deployment is deleted.
+	ReasonClusterUnavailable = "ClusterUnavailable"
+
+	// ReasonSplitFailed fires when reconcile can't apply a root's
+	// virtual Deployments or status.
+	ReasonSplitFailed = "SplitFailed"
+)
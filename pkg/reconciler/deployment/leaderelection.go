@@ -0,0 +1,162 @@
+package deployment
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+)
+
+// leaderElectionConfig holds the lease identity and timings an operator
+// can tune via WithLeaderElection/WithLeaderElectionDurations. It's left
+// at its zero value (enabled: false) for single-replica use, matching
+// how the controller has always run.
+type leaderElectionConfig struct {
+	enabled                                   bool
+	namespace, name, identity                 string
+	leaseDuration, renewDeadline, retryPeriod time.Duration
+}
+
+// WithLeaderElection enables leader election via a Lease named name in
+// namespace, held under identity (typically the pod name). Only the
+// replica that wins the lease calls Start; the rest stay on standby
+// with cold informers.
+func WithLeaderElection(namespace, name, identity string) Option {
+	return func(c *Controller) {
+		c.leaderElection.enabled = true
+		c.leaderElection.namespace = namespace
+		c.leaderElection.name = name
+		c.leaderElection.identity = identity
+	}
+}
+
+// WithLeaderElectionDurations overrides the default lease/renew/retry
+// durations (15s/10s/2s, filled in by NewController for any left at
+// zero). Has no effect unless
+// WithLeaderElection is also used.
+func WithLeaderElectionDurations(lease, renew, retry time.Duration) Option {
+	return func(c *Controller) {
+		c.leaderElection.leaseDuration = lease
+		c.leaderElection.renewDeadline = renew
+		c.leaderElection.retryPeriod = retry
+	}
+}
+
+func (c *Controller) applyLeaderElectionDefaults() {
+	if !c.leaderElection.enabled {
+		return
+	}
+	if c.leaderElection.leaseDuration == 0 {
+		c.leaderElection.leaseDuration = 15 * time.Second
+	}
+	if c.leaderElection.renewDeadline == 0 {
+		c.leaderElection.renewDeadline = 10 * time.Second
+	}
+	if c.leaderElection.retryPeriod == 0 {
+		c.leaderElection.retryPeriod = 2 * time.Second
+	}
+}
+
+var leaderTransitionsTotal = metrics.NewCounter(&metrics.CounterOpts{
+	Name: "deployment_splitter_leader_transitions_total",
+	Help: "Number of times this process has become the leader of the deployment splitter's Lease.",
+})
+
+func init() {
+	legacyregistry.MustRegister(leaderTransitionsTotal)
+}
+
+// Run is the leader-election-aware entrypoint: it waits on standby (cold
+// informers, no workers) until this replica acquires the Lease named by
+// WithLeaderElection, then runs Start(numThreads) while it holds it.
+// Run returns once ctx is canceled or the Lease is lost; it does not go
+// back to standby or campaign again afterwards. If leader election wasn't
+// configured, it's equivalent to calling Start(ctx, numThreads)
+// directly against the informers NewController already started.
+func (c *Controller) Run(ctx context.Context, numThreads int) error {
+	if !c.leaderElection.enabled {
+		c.Start(ctx, numThreads)
+		return nil
+	}
+
+	logger := klog.FromContext(ctx)
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      c.leaderElection.name,
+			Namespace: c.leaderElection.namespace,
+		},
+		Client: c.kubeClient.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity:      c.leaderElection.identity,
+			EventRecorder: c.recorder,
+		},
+	}
+
+	le, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		ReleaseOnCancel: true,
+		LeaseDuration:   c.leaderElection.leaseDuration,
+		RenewDeadline:   c.leaderElection.renewDeadline,
+		RetryPeriod:     c.leaderElection.retryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				logger.Info("Became leader, starting informers and workers", "identity", c.leaderElection.identity)
+				leaderTransitionsTotal.Inc()
+				c.leading.Store(true)
+
+				c.startInformers(ctx)
+				done := make(chan struct{})
+				go func() {
+					defer close(done)
+					c.Start(ctx, numThreads)
+				}()
+
+				// Hold this callback open until Start has fully drained.
+				// The elector runs this callback in its own goroutine, so
+				// this does not delay the lease release (ReleaseOnCancel)
+				// or OnStoppedLeading: in-flight process calls can still
+				// be running after the Lease has been released.
+				<-done
+			},
+			OnStoppedLeading: func() {
+				c.leading.Store(false)
+				logger.Info("Stopped leading", "identity", c.leaderElection.identity)
+			},
+			OnNewLeader: func(identity string) {
+				if identity == c.leaderElection.identity {
+					return
+				}
+				logger.Info("New leader elected", "identity", identity)
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("creating leader elector: %w", err)
+	}
+
+	le.Run(ctx)
+	return nil
+}
+
+// ReadyzHandler reports "ok" once this replica holds the Lease (or when
+// leader election isn't configured at all), and "standby" otherwise, so
+// a Deployment of replicas can run with only the leader passing a
+// readiness probe.
+func (c *Controller) ReadyzHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !c.leaderElection.enabled || c.leading.Load() {
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprintln(w, "ok")
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, "standby")
+	})
+}
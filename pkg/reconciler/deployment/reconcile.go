@@ -0,0 +1,246 @@
+package deployment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	appsv1apply "k8s.io/client-go/applyconfigurations/apps/v1"
+)
+
+const (
+	// ownedByLabel is set on every virtual Deployment to the name of the
+	// root Deployment it was split from. Root Deployments never carry
+	// this label, which is how processCluster tells them apart from the
+	// virtual Deployments it creates.
+	ownedByLabel = "deployment.kcp.dev/owned-by"
+
+	// clusterNameLabel is set on every virtual Deployment to the name of
+	// the Cluster it was placed on.
+	clusterNameLabel = "deployment.kcp.dev/cluster"
+
+	// unschedulableReplicasAnnotation records, on the root Deployment,
+	// how many of its desired replicas the Scheduler couldn't place on
+	// any Cluster in the last reconcile.
+	unschedulableReplicasAnnotation = "scheduling.kcp.dev/unschedulable-replicas"
+
+	// FieldManager identifies this controller to Server-Side Apply. It's
+	// stable across restarts so re-applying the same fields is always a
+	// no-op conflict-wise, and distinct enough that other controllers can
+	// co-own labels/annotations on the Deployments this one splits
+	// without fighting over them.
+	FieldManager = "kcp-deployment-splitter"
+)
+
+// reconcile splits root's replicas across the Clusters available to it,
+// applying one virtual Deployment per Cluster it's scheduled onto via
+// Server-Side Apply. It is minimally disruptive: an existing virtual
+// Deployment's replica count is left alone if the new schedule is
+// within ±1 of it, so a Cluster event doesn't churn every virtual
+// Deployment on every run.
+func (c *Controller) reconcile(ctx context.Context, root *appsv1.Deployment) error {
+	clusters, err := c.clusterLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+
+	assignments, err := c.scheduler.Schedule(root, clusters)
+	if err != nil {
+		c.recorder.Eventf(root, corev1.EventTypeWarning, ReasonSplitFailed, "scheduling replicas across clusters: %v", err)
+		return fmt.Errorf("scheduling replicas across clusters: %w", err)
+	}
+
+	existing, err := c.virtualDeployments(root)
+	if err != nil {
+		return err
+	}
+
+	hadExisting := len(existing) > 0
+	rebalanced := false
+
+	seen := map[string]bool{}
+	var scheduled, appliedReplicas, totalReplicas, readyReplicas int32
+	for _, a := range assignments {
+		scheduled += a.Replicas
+		seen[a.ClusterName] = true
+
+		replicas := a.Replicas
+		if prev, ok := existing[a.ClusterName]; ok {
+			prevReplicas := int32(1)
+			if prev.Spec.Replicas != nil {
+				prevReplicas = *prev.Spec.Replicas
+			}
+			if abs32(prevReplicas-replicas) <= 1 {
+				replicas = prevReplicas
+			} else {
+				rebalanced = true
+			}
+		} else if hadExisting {
+			rebalanced = true
+		}
+
+		virtualApply, err := buildVirtualDeploymentApply(root, a.ClusterName, replicas)
+		if err != nil {
+			c.recorder.Eventf(root, corev1.EventTypeWarning, ReasonSplitFailed, "building apply configuration for cluster %q: %v", a.ClusterName, err)
+			return fmt.Errorf("building apply configuration for cluster %q: %w", a.ClusterName, err)
+		}
+		applied, err := c.kubeClient.AppsV1().Deployments(root.Namespace).Apply(ctx, virtualApply, c.applyOptions())
+		if err != nil {
+			c.recorder.Eventf(root, corev1.EventTypeWarning, ReasonSplitFailed, "applying virtual deployment for cluster %q: %v", a.ClusterName, err)
+			return fmt.Errorf("applying virtual deployment for cluster %q: %w", a.ClusterName, err)
+		}
+		appliedReplicas += replicas
+		totalReplicas += applied.Status.Replicas
+		readyReplicas += applied.Status.ReadyReplicas
+	}
+
+	// Clusters that no longer appear in the schedule (removed, gone
+	// NotReady, or at capacity) lose their virtual Deployment.
+	var clusterUnavailable bool
+	for clusterName, prev := range existing {
+		if seen[clusterName] {
+			continue
+		}
+		if err := c.kubeClient.AppsV1().Deployments(prev.Namespace).Delete(ctx, prev.Name, metav1.DeleteOptions{}); err != nil {
+			c.recorder.Eventf(root, corev1.EventTypeWarning, ReasonSplitFailed, "removing virtual deployment for unavailable cluster %q: %v", clusterName, err)
+			return err
+		}
+		clusterUnavailable = true
+	}
+
+	switch {
+	case !hadExisting && len(assignments) > 0:
+		c.recorder.Eventf(root, corev1.EventTypeNormal, ReasonSplitAcrossClusters, "Split across %d cluster(s)", len(assignments))
+	case rebalanced:
+		c.recorder.Eventf(root, corev1.EventTypeNormal, ReasonRebalanced, "Rebalanced across %d cluster(s)", len(assignments))
+	}
+	if clusterUnavailable {
+		c.recorder.Event(root, corev1.EventTypeWarning, ReasonClusterUnavailable, "One or more clusters are no longer available; their virtual deployments were removed")
+	}
+
+	overflow := desiredReplicas(root) - scheduled
+	annotations := setCondition(nil, ConditionScheduled, overflow <= 0)
+	// Compare against appliedReplicas (the per-shard spec replicas this
+	// reconcile actually applied, after ±1 churn-avoidance), not the
+	// theoretical scheduled total: a smoothed shard's Status.Replicas
+	// converges to the old value, not the new schedule, so comparing
+	// against scheduled would never be satisfied once any shard smoothed.
+	annotations = setCondition(annotations, ConditionAllShardsReady, overflow <= 0 && appliedReplicas == totalReplicas && readyReplicas == totalReplicas)
+	if overflow > 0 {
+		annotations[unschedulableReplicasAnnotation] = strconv.Itoa(int(overflow))
+	}
+
+	rootApply := appsv1apply.Deployment(root.Name, root.Namespace).WithAnnotations(annotations)
+	if _, err := c.kubeClient.AppsV1().Deployments(root.Namespace).Apply(ctx, rootApply, c.applyOptions()); err != nil {
+		c.recorder.Eventf(root, corev1.EventTypeWarning, ReasonSplitFailed, "applying root deployment metadata: %v", err)
+		return fmt.Errorf("applying root deployment metadata: %w", err)
+	}
+
+	statusApply := appsv1apply.Deployment(root.Name, root.Namespace).WithStatus(
+		appsv1apply.DeploymentStatus().
+			WithReplicas(totalReplicas).
+			WithReadyReplicas(readyReplicas),
+	)
+	if _, err := c.kubeClient.AppsV1().Deployments(root.Namespace).ApplyStatus(ctx, statusApply, c.applyOptions()); err != nil {
+		c.recorder.Eventf(root, corev1.EventTypeWarning, ReasonSplitFailed, "applying root deployment status: %v", err)
+		return fmt.Errorf("applying root deployment status: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Controller) applyOptions() metav1.ApplyOptions {
+	return metav1.ApplyOptions{FieldManager: FieldManager, Force: c.forceApply}
+}
+
+// virtualDeployments returns root's existing virtual Deployments, keyed
+// by the Cluster they're placed on.
+func (c *Controller) virtualDeployments(root *appsv1.Deployment) (map[string]*appsv1.Deployment, error) {
+	sel := labels.SelectorFromSet(labels.Set{ownedByLabel: root.Name})
+	ds, err := c.lister.Deployments(root.Namespace).List(sel)
+	if err != nil {
+		return nil, err
+	}
+	byCluster := make(map[string]*appsv1.Deployment, len(ds))
+	for _, d := range ds {
+		if clusterName, ok := d.Labels[clusterNameLabel]; ok {
+			byCluster[clusterName] = d
+		}
+	}
+	return byCluster, nil
+}
+
+// buildVirtualDeploymentApply returns the Server-Side Apply
+// configuration that places replicas copies of root on cluster. The
+// Selector and PodTemplateSpec are copied from root unchanged; only
+// Replicas and our own labels differ between virtual Deployments.
+func buildVirtualDeploymentApply(root *appsv1.Deployment, clusterName string, replicas int32) (*appsv1apply.DeploymentApplyConfiguration, error) {
+	spec, err := deploymentSpecApplyConfiguration(root.Spec, replicas)
+	if err != nil {
+		return nil, err
+	}
+
+	virtualLabels := make(map[string]string, len(root.Labels)+2)
+	for k, v := range root.Labels {
+		virtualLabels[k] = v
+	}
+	// Set last so a same-named label on root can never shadow the
+	// controller's own ownership/placement labels.
+	virtualLabels[ownedByLabel] = root.Name
+	virtualLabels[clusterNameLabel] = clusterName
+
+	return appsv1apply.Deployment(virtualDeploymentName(root.Name, clusterName), root.Namespace).
+		WithLabels(virtualLabels).
+		WithSpec(spec), nil
+}
+
+// deploymentSpecApplyConfiguration adapts root's Selector and
+// PodTemplateSpec into a DeploymentSpecApplyConfiguration carrying
+// replicas instead of root's own replica count. The apply configuration
+// types are generated with the same JSON shape as their API
+// counterparts, so round-tripping through JSON is a reliable way to
+// convert a full, already-built DeploymentSpec without hand-writing a
+// field-by-field PodTemplateSpec conversion.
+func deploymentSpecApplyConfiguration(spec appsv1.DeploymentSpec, replicas int32) (*appsv1apply.DeploymentSpecApplyConfiguration, error) {
+	spec = *spec.DeepCopy()
+	spec.Replicas = &replicas
+
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	var ac appsv1apply.DeploymentSpecApplyConfiguration
+	if err := json.Unmarshal(data, &ac); err != nil {
+		return nil, err
+	}
+	return &ac, nil
+}
+
+func virtualDeploymentName(rootName, clusterName string) string {
+	return fmt.Sprintf("%s-%s", rootName, clusterName)
+}
+
+func parseInt32Annotation(annotations map[string]string, key string) (int32, bool) {
+	v, ok := annotations[key]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return int32(n), true
+}
+
+func abs32(n int32) int32 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,235 @@
+package deployment
+
+import (
+	"fmt"
+
+	clusterv1alpha1 "github.com/kcp-dev/kcp/pkg/apis/cluster/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	appsv1lister "k8s.io/client-go/listers/apps/v1"
+)
+
+const (
+	// weightAnnotation lets a Cluster advertise its relative share of
+	// replicas when a root Deployment is split. Clusters without this
+	// annotation get a weight of 1.
+	weightAnnotation = "scheduling.kcp.dev/weight"
+
+	// maxReplicasAnnotation caps how many replicas of a single root
+	// Deployment a Cluster is willing to run. Clusters without this
+	// annotation are treated as uncapped.
+	maxReplicasAnnotation = "scheduling.kcp.dev/max-replicas"
+)
+
+// Assignment is the number of replicas of a root Deployment that a
+// Scheduler has placed on a given Cluster.
+type Assignment struct {
+	ClusterName string
+	Replicas    int32
+}
+
+// Scheduler decides how a root Deployment's replicas are distributed
+// across the Ready Clusters available to it. Implementations must not
+// mutate root or clusters. Replicas that don't fit anywhere, because
+// every Ready cluster is at its scheduling.kcp.dev/max-replicas cap,
+// are simply omitted from the result; the caller surfaces that as
+// overflow.
+type Scheduler interface {
+	Schedule(root *appsv1.Deployment, clusters []*clusterv1alpha1.Cluster) ([]Assignment, error)
+}
+
+// WeightedRoundRobin distributes replicas proportionally to each
+// Cluster's scheduling.kcp.dev/weight annotation, honoring any
+// per-cluster scheduling.kcp.dev/max-replicas cap. It is the default
+// Scheduler.
+type WeightedRoundRobin struct{}
+
+var _ Scheduler = WeightedRoundRobin{}
+
+func (WeightedRoundRobin) Schedule(root *appsv1.Deployment, clusters []*clusterv1alpha1.Cluster) ([]Assignment, error) {
+	ready := readyClusters(clusters)
+	if len(ready) == 0 {
+		return nil, nil
+	}
+
+	weights := make([]int32, len(ready))
+	caps := make([]int32, len(ready))
+	var totalWeight int32
+	for i, cluster := range ready {
+		weights[i] = clusterWeight(cluster)
+		caps[i] = clusterMaxReplicas(cluster)
+		totalWeight += weights[i]
+	}
+
+	assignments := make([]Assignment, len(ready))
+	for i, cluster := range ready {
+		assignments[i] = Assignment{ClusterName: cluster.Name}
+	}
+
+	// Place replicas one at a time on whichever non-capped cluster is
+	// furthest below its proportional share. This keeps the split
+	// proportional to weight while respecting caps, without any
+	// remainder-rounding edge cases.
+	for placed, wanted := int32(0), desiredReplicas(root); placed < wanted; placed++ {
+		best := -1
+		var bestDeficit float64
+		for i := range ready {
+			if caps[i] >= 0 && assignments[i].Replicas >= caps[i] {
+				continue
+			}
+			share := float64(weights[i]) / float64(totalWeight)
+			deficit := share*float64(placed+1) - float64(assignments[i].Replicas)
+			if best == -1 || deficit > bestDeficit {
+				best = i
+				bestDeficit = deficit
+			}
+		}
+		if best == -1 {
+			// Every Ready cluster is at its cap; the rest is overflow.
+			break
+		}
+		assignments[best].Replicas++
+	}
+
+	return nonZero(assignments), nil
+}
+
+// LeastLoaded distributes a root Deployment's replicas to whichever
+// Ready Cluster currently runs the fewest total replicas across all
+// virtual Deployments, per the lister, rather than by weight. This
+// balances overall cluster utilization instead of per-root share. It
+// still honors scheduling.kcp.dev/max-replicas.
+type LeastLoaded struct {
+	lister appsv1lister.DeploymentLister
+}
+
+// NewLeastLoaded returns a LeastLoaded scheduler that consults lister
+// for each Cluster's current total virtual Deployment replica count.
+func NewLeastLoaded(lister appsv1lister.DeploymentLister) *LeastLoaded {
+	return &LeastLoaded{lister: lister}
+}
+
+var _ Scheduler = &LeastLoaded{}
+
+func (s *LeastLoaded) Schedule(root *appsv1.Deployment, clusters []*clusterv1alpha1.Cluster) ([]Assignment, error) {
+	ready := readyClusters(clusters)
+	if len(ready) == 0 {
+		return nil, nil
+	}
+
+	// Exclude root's own existing shards from the load tally: they're
+	// about to be re-placed by this very call, so counting them would
+	// bias the scheduler away from clusters root already occupies on
+	// every reschedule.
+	load, err := clusterLoad(s.lister, root.Name)
+	if err != nil {
+		return nil, fmt.Errorf("listing virtual deployments to compute cluster load: %w", err)
+	}
+
+	caps := make([]int32, len(ready))
+	assignments := make([]Assignment, len(ready))
+	for i, cluster := range ready {
+		caps[i] = clusterMaxReplicas(cluster)
+		assignments[i] = Assignment{ClusterName: cluster.Name}
+	}
+
+	for placed, wanted := int32(0), desiredReplicas(root); placed < wanted; placed++ {
+		best := -1
+		for i, cluster := range ready {
+			if caps[i] >= 0 && assignments[i].Replicas >= caps[i] {
+				continue
+			}
+			projected := load[cluster.Name] + assignments[i].Replicas
+			if best == -1 || projected < load[ready[best].Name]+assignments[best].Replicas {
+				best = i
+			}
+		}
+		if best == -1 {
+			break
+		}
+		assignments[best].Replicas++
+	}
+
+	return nonZero(assignments), nil
+}
+
+// clusterLoad sums Spec.Replicas of every virtual Deployment not owned
+// by excludeRoot, grouped by the Cluster it's placed on, across all
+// other roots.
+func clusterLoad(lister appsv1lister.DeploymentLister, excludeRoot string) (map[string]int32, error) {
+	all, err := lister.List(labels.SelectorFromSet(labels.Set{}))
+	if err != nil {
+		return nil, err
+	}
+	load := map[string]int32{}
+	for _, d := range all {
+		if d.Labels[ownedByLabel] == excludeRoot {
+			continue
+		}
+		clusterName, ok := d.Labels[clusterNameLabel]
+		if !ok {
+			continue
+		}
+		replicas := int32(1)
+		if d.Spec.Replicas != nil {
+			replicas = *d.Spec.Replicas
+		}
+		load[clusterName] += replicas
+	}
+	return load, nil
+}
+
+func desiredReplicas(root *appsv1.Deployment) int32 {
+	if root.Spec.Replicas == nil {
+		return 1
+	}
+	return *root.Spec.Replicas
+}
+
+func readyClusters(clusters []*clusterv1alpha1.Cluster) []*clusterv1alpha1.Cluster {
+	ready := make([]*clusterv1alpha1.Cluster, 0, len(clusters))
+	for _, c := range clusters {
+		if isClusterReady(c) {
+			ready = append(ready, c)
+		}
+	}
+	return ready
+}
+
+func isClusterReady(cluster *clusterv1alpha1.Cluster) bool {
+	for _, cond := range cluster.Status.Conditions {
+		if cond.Type == clusterv1alpha1.ClusterConditionReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func clusterWeight(cluster *clusterv1alpha1.Cluster) int32 {
+	w, ok := parseInt32Annotation(cluster.Annotations, weightAnnotation)
+	if !ok || w <= 0 {
+		return 1
+	}
+	return w
+}
+
+// clusterMaxReplicas returns the Cluster's replica cap, or -1 if it has
+// none.
+func clusterMaxReplicas(cluster *clusterv1alpha1.Cluster) int32 {
+	m, ok := parseInt32Annotation(cluster.Annotations, maxReplicasAnnotation)
+	if !ok || m < 0 {
+		return -1
+	}
+	return m
+}
+
+func nonZero(assignments []Assignment) []Assignment {
+	out := assignments[:0]
+	for _, a := range assignments {
+		if a.Replicas > 0 {
+			out = append(out, a)
+		}
+	}
+	return out
+}
@@ -0,0 +1,37 @@
+// Package signals provides a SIGTERM/SIGINT-aware context for
+// controllers, modeled on the contextual-logging migration in
+// k8s.io/sample-controller.
+package signals
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+var onlyOneSignalHandler = make(chan struct{})
+
+// shutdownSignals are the signals that trigger a graceful shutdown. A
+// second signal causes an immediate os.Exit(1).
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// SetupSignalContext registers a handler for SIGTERM/SIGINT and returns
+// a context that's canceled when one is received. It panics if called
+// more than once.
+func SetupSignalContext() context.Context {
+	close(onlyOneSignalHandler) // panics when called twice
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, shutdownSignals...)
+	go func() {
+		<-c
+		cancel()
+		<-c
+		os.Exit(1) // second signal, exit immediately
+	}()
+
+	return ctx
+}